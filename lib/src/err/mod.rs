@@ -194,12 +194,30 @@ pub enum Error {
 		value: String,
 	},
 
+	/// Can not execute KILL query using a parameter which has not been set
+	#[error("Can not execute KILL query using the unset parameter '{name}'")]
+	KillStatementParam {
+		name: String,
+	},
+
 	/// The permissions do not allow this query to be run on this table
 	#[error("You don't have permission to run this query on the `{table}` table")]
 	TablePermissions {
 		table: String,
 	},
 
+	/// Live queries are not enabled on this table
+	#[error("Live queries are not enabled on the `{table}` table")]
+	TableLiveQueries {
+		table: String,
+	},
+
+	/// Live queries can not be started on a foreign table view
+	#[error("Unable to start a live query on the `{table}` table while setup as a view")]
+	TableViewLiveQueries {
+		table: String,
+	},
+
 	/// The specified table can not be written as it is setup as a foreign table view
 	#[error("Unable to write to the `{table}` table while setup as a view")]
 	TableIsView {
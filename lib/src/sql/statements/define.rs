@@ -563,6 +563,8 @@ pub struct DefineTableStatement {
 	pub full: bool,
 	pub view: Option<View>,
 	pub permissions: Permissions,
+	#[serde(default)]
+	pub nolive: bool,
 }
 
 impl DefineTableStatement {
@@ -628,6 +630,9 @@ impl fmt::Display for DefineTableStatement {
 		if !self.full {
 			write!(f, " SCHEMALESS")?
 		}
+		if self.nolive {
+			write!(f, " NOLIVE")?
+		}
 		if let Some(ref v) = self.view {
 			write!(f, " {}", v)?
 		}
@@ -675,6 +680,13 @@ fn table(i: &str) -> IResult<&str, DefineTableStatement> {
 					_ => None,
 				})
 				.unwrap_or_default(),
+			nolive: opts
+				.iter()
+				.find_map(|x| match x {
+					DefineTableOption::Nolive => Some(true),
+					_ => None,
+				})
+				.unwrap_or_default(),
 		},
 	))
 }
@@ -686,10 +698,18 @@ pub enum DefineTableOption {
 	Schemaless,
 	Schemafull,
 	Permissions(Permissions),
+	Nolive,
 }
 
 fn table_opts(i: &str) -> IResult<&str, DefineTableOption> {
-	alt((table_drop, table_view, table_schemaless, table_schemafull, table_permissions))(i)
+	alt((
+		table_drop,
+		table_view,
+		table_schemaless,
+		table_schemafull,
+		table_permissions,
+		table_nolive,
+	))(i)
 }
 
 fn table_drop(i: &str) -> IResult<&str, DefineTableOption> {
@@ -716,6 +736,12 @@ fn table_schemafull(i: &str) -> IResult<&str, DefineTableOption> {
 	Ok((i, DefineTableOption::Schemafull))
 }
 
+fn table_nolive(i: &str) -> IResult<&str, DefineTableOption> {
+	let (i, _) = shouldbespace(i)?;
+	let (i, _) = tag_no_case("NOLIVE")(i)?;
+	Ok((i, DefineTableOption::Nolive))
+}
+
 fn table_permissions(i: &str) -> IResult<&str, DefineTableOption> {
 	let (i, _) = shouldbespace(i)?;
 	let (i, v) = permissions(i)?;
@@ -5,25 +5,28 @@ use crate::dbs::Transaction;
 use crate::err::Error;
 use crate::sql::comment::shouldbespace;
 use crate::sql::error::IResult;
-use crate::sql::uuid::{uuid, Uuid};
+use crate::sql::param::param;
+use crate::sql::uuid::uuid;
 use crate::sql::value::Value;
 use derive::Store;
+use nom::branch::alt;
 use nom::bytes::complete::tag_no_case;
+use nom::combinator::map;
 use serde::{Deserialize, Serialize};
 use std::fmt;
 
 #[derive(Clone, Debug, Default, Eq, PartialEq, Serialize, Deserialize, Store)]
 pub struct KillStatement {
-	pub id: Uuid,
+	pub id: Value,
 }
 
 impl KillStatement {
 	pub(crate) async fn compute(
 		&self,
-		_ctx: &Context<'_>,
+		ctx: &Context<'_>,
 		opt: &Options,
 		txn: &Transaction,
-		_doc: Option<&Value>,
+		doc: Option<&Value>,
 	) -> Result<Value, Error> {
 		// Allowed to run?
 		opt.realtime()?;
@@ -31,32 +34,51 @@ impl KillStatement {
 		opt.needs(Level::Db)?;
 		// Allowed to run?
 		opt.check(Level::No)?;
+		// Process the live query id
+		let id = match self.id.compute(ctx, opt, txn, doc).await? {
+			Value::Uuid(id) => id,
+			Value::None => {
+				return Err(match &self.id {
+					Value::Param(v) => Error::KillStatementParam {
+						name: v.to_string(),
+					},
+					v => Error::KillStatement {
+						value: v.to_string(),
+					},
+				})
+			}
+			v => {
+				return Err(Error::KillStatement {
+					value: v.to_string(),
+				})
+			}
+		};
 		// Clone transaction
 		let run = txn.clone();
 		// Claim transaction
 		let mut run = run.lock().await;
 		// Create the live query key
-		let key = crate::key::lq::new(opt.ns(), opt.db(), &self.id);
+		let key = crate::key::lq::new(opt.ns(), opt.db(), &id);
 		// Fetch the live query key if it exists
 		match run.get(key).await? {
 			Some(val) => match std::str::from_utf8(&val) {
 				Ok(tb) => {
 					// Delete the live query
-					let key = crate::key::lq::new(opt.ns(), opt.db(), &self.id);
+					let key = crate::key::lq::new(opt.ns(), opt.db(), &id);
 					run.del(key).await?;
 					// Delete the table live query
-					let key = crate::key::lv::new(opt.ns(), opt.db(), tb, &self.id);
+					let key = crate::key::lv::new(opt.ns(), opt.db(), tb, &id);
 					run.del(key).await?;
 				}
 				_ => {
 					return Err(Error::KillStatement {
-						value: self.id.to_string(),
+						value: id.to_string(),
 					})
 				}
 			},
 			None => {
 				return Err(Error::KillStatement {
-					value: self.id.to_string(),
+					value: id.to_string(),
 				})
 			}
 		}
@@ -74,7 +96,7 @@ impl fmt::Display for KillStatement {
 pub fn kill(i: &str) -> IResult<&str, KillStatement> {
 	let (i, _) = tag_no_case("KILL")(i)?;
 	let (i, _) = shouldbespace(i)?;
-	let (i, v) = uuid(i)?;
+	let (i, v) = alt((map(uuid, Value::from), map(param, Value::from)))(i)?;
 	Ok((
 		i,
 		KillStatement {
@@ -82,3 +104,27 @@ pub fn kill(i: &str) -> IResult<&str, KillStatement> {
 		},
 	))
 }
+
+#[cfg(test)]
+mod tests {
+
+	use super::*;
+
+	#[test]
+	fn kill_statement_uuid() {
+		let sql = "KILL \"e72bee20-f49b-11ec-b939-0242ac120002\"";
+		let res = kill(sql);
+		assert!(res.is_ok());
+		let out = res.unwrap().1;
+		assert_eq!(sql, format!("{}", out))
+	}
+
+	#[test]
+	fn kill_statement_param() {
+		let sql = "KILL $id";
+		let res = kill(sql);
+		assert!(res.is_ok());
+		let out = res.unwrap().1;
+		assert_eq!(sql, format!("{}", out))
+	}
+}
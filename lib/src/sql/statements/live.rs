@@ -1,8 +1,10 @@
+use crate::cnf;
 use crate::ctx::Context;
 use crate::dbs::Level;
 use crate::dbs::Options;
 use crate::dbs::Transaction;
 use crate::err::Error;
+use crate::kvs;
 use crate::sql::comment::shouldbespace;
 use crate::sql::cond::{cond, Cond};
 use crate::sql::error::IResult;
@@ -10,6 +12,7 @@ use crate::sql::fetch::{fetch, Fetchs};
 use crate::sql::field::{fields, Fields};
 use crate::sql::param::param;
 use crate::sql::table::table;
+use crate::sql::thing::thing;
 use crate::sql::uuid::Uuid;
 use crate::sql::value::Value;
 use derive::Store;
@@ -31,6 +34,35 @@ pub struct LiveStatement {
 }
 
 impl LiveStatement {
+	async fn check(
+		&self,
+		opt: &Options,
+		run: &mut kvs::Transaction,
+		tb: &str,
+	) -> Result<(), Error> {
+		// Check the table definition
+		let dt = match run.get_tb(opt.ns(), opt.db(), tb).await {
+			Ok(dt) => dt,
+			// Live queries can be started on undefined tables
+			Err(Error::TbNotFound) => return Ok(()),
+			Err(e) => return Err(e),
+		};
+		// Views are not supported by live queries
+		if dt.view.is_some() {
+			return Err(Error::TableViewLiveQueries {
+				table: tb.to_owned(),
+			});
+		}
+		// Check that live queries are enabled
+		if dt.nolive && (!cnf::NOLIVE_PRIVILEGED_OVERRIDE || (opt.perms && opt.auth.perms())) {
+			return Err(Error::TableLiveQueries {
+				table: tb.to_owned(),
+			});
+		}
+		// Continue with the live query
+		Ok(())
+	}
+
 	pub(crate) async fn compute(
 		&self,
 		ctx: &Context<'_>,
@@ -48,22 +80,31 @@ impl LiveStatement {
 		let run = txn.clone();
 		// Claim transaction
 		let mut run = run.lock().await;
+		// Process the live query target
+		let what = self.what.compute(ctx, opt, txn, doc).await?;
 		// Process the live query table
-		match self.what.compute(ctx, opt, txn, doc).await? {
-			Value::Table(tb) => {
-				// Insert the live query
-				let key = crate::key::lq::new(opt.ns(), opt.db(), &self.id);
-				run.putc(key, tb.as_str(), None).await?;
-				// Insert the table live query
-				let key = crate::key::lv::new(opt.ns(), opt.db(), &tb, &self.id);
-				run.putc(key, self.clone(), None).await?;
-			}
+		let tb = match &what {
+			Value::Table(tb) => tb.0.to_owned(),
+			Value::Thing(th) => th.tb.to_owned(),
 			v => {
 				return Err(Error::LiveStatement {
 					value: v.to_string(),
 				})
 			}
 		};
+		// Check the live query table
+		self.check(opt, &mut run, &tb).await?;
+		// Insert the live query
+		let key = crate::key::lq::new(opt.ns(), opt.db(), &self.id);
+		run.putc(key, tb.as_str(), None).await?;
+		// Store the computed live query target
+		let stm = LiveStatement {
+			what,
+			..self.clone()
+		};
+		// Insert the table live query
+		let key = crate::key::lv::new(opt.ns(), opt.db(), &tb, &self.id);
+		run.putc(key, stm, None).await?;
 		// Return the query id
 		Ok(self.id.clone().into())
 	}
@@ -89,7 +130,8 @@ pub fn live(i: &str) -> IResult<&str, LiveStatement> {
 	let (i, _) = shouldbespace(i)?;
 	let (i, _) = tag_no_case("FROM")(i)?;
 	let (i, _) = shouldbespace(i)?;
-	let (i, what) = alt((map(param, Value::from), map(table, Value::from)))(i)?;
+	let (i, what) =
+		alt((map(param, Value::from), map(thing, Value::from), map(table, Value::from)))(i)?;
 	let (i, cond) = opt(preceded(shouldbespace, cond))(i)?;
 	let (i, fetch) = opt(preceded(shouldbespace, fetch))(i)?;
 	Ok((
@@ -103,3 +145,36 @@ pub fn live(i: &str) -> IResult<&str, LiveStatement> {
 		},
 	))
 }
+
+#[cfg(test)]
+mod tests {
+
+	use super::*;
+
+	#[test]
+	fn live_statement_param() {
+		let sql = "LIVE SELECT * FROM $test";
+		let res = live(sql);
+		assert!(res.is_ok());
+		let out = res.unwrap().1;
+		assert_eq!(sql, format!("{}", out))
+	}
+
+	#[test]
+	fn live_statement_table() {
+		let sql = "LIVE SELECT * FROM test";
+		let res = live(sql);
+		assert!(res.is_ok());
+		let out = res.unwrap().1;
+		assert_eq!(sql, format!("{}", out))
+	}
+
+	#[test]
+	fn live_statement_thing() {
+		let sql = "LIVE SELECT * FROM test:thingy";
+		let res = live(sql);
+		assert!(res.is_ok());
+		let out = res.unwrap().1;
+		assert_eq!(sql, format!("{}", out))
+	}
+}
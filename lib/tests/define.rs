@@ -141,6 +141,64 @@ async fn define_statement_table_schemafull() -> Result<(), Error> {
 	Ok(())
 }
 
+#[tokio::test]
+async fn define_statement_table_nolive() -> Result<(), Error> {
+	let sql = "
+		DEFINE TABLE test NOLIVE;
+		INFO FOR DB;
+	";
+	let dbs = Datastore::new("memory").await?;
+	let ses = Session::for_kv().with_ns("test").with_db("test");
+	let res = &mut dbs.execute(&sql, &ses, None, false).await?;
+	assert_eq!(res.len(), 2);
+	//
+	let tmp = res.remove(0).result;
+	assert!(tmp.is_ok());
+	//
+	let tmp = res.remove(0).result?;
+	let val = Value::parse(
+		"{
+			dl: {},
+			dt: {},
+			sc: {},
+			tb: { test: 'DEFINE TABLE test SCHEMALESS NOLIVE' },
+		}",
+	);
+	assert_eq!(tmp, val);
+	//
+	let sql = "LIVE SELECT * FROM test";
+	let mut ses = Session::for_sc("test", "test", "test");
+	ses.rt = true;
+	let res = &mut dbs.execute(&sql, &ses, None, false).await?;
+	assert_eq!(res.len(), 1);
+	//
+	let tmp = res.remove(0).result;
+	assert!(matches!(tmp, Err(Error::TableLiveQueries { .. })));
+	//
+	Ok(())
+}
+
+#[tokio::test]
+async fn define_statement_table_view_live() -> Result<(), Error> {
+	let sql = "
+		DEFINE TABLE test AS SELECT temp FROM person GROUP BY temp;
+		LIVE SELECT * FROM test;
+	";
+	let dbs = Datastore::new("memory").await?;
+	let mut ses = Session::for_kv().with_ns("test").with_db("test");
+	ses.rt = true;
+	let res = &mut dbs.execute(&sql, &ses, None, false).await?;
+	assert_eq!(res.len(), 2);
+	//
+	let tmp = res.remove(0).result;
+	assert!(tmp.is_ok());
+	//
+	let tmp = res.remove(0).result;
+	assert!(matches!(tmp, Err(Error::TableViewLiveQueries { .. })));
+	//
+	Ok(())
+}
+
 #[tokio::test]
 async fn define_statement_event() -> Result<(), Error> {
 	let sql = "
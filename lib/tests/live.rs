@@ -0,0 +1,74 @@
+use std::collections::BTreeMap;
+use surrealdb::sql::Thing;
+use surrealdb::sql::Value;
+use surrealdb::Datastore;
+use surrealdb::Error;
+use surrealdb::Session;
+
+#[tokio::test]
+async fn live_statement_thing_param() -> Result<(), Error> {
+	let sql = "LIVE SELECT * FROM $tb";
+	let dbs = Datastore::new("memory").await?;
+	let mut ses = Session::for_kv().with_ns("test").with_db("test");
+	ses.rt = true;
+	let th = Thing {
+		tb: String::from("person"),
+		id: "tobie".into(),
+	};
+	let mut var = BTreeMap::new();
+	var.insert(String::from("tb"), Value::from(th.clone()));
+	let res = &mut dbs.execute(&sql, &ses, Some(var), false).await?;
+	assert_eq!(res.len(), 1);
+	//
+	let tmp = res.remove(0).result;
+	assert!(tmp.is_ok());
+	//
+	let mut txn = dbs.transaction(false, false).await?;
+	let lvs = txn.all_lv("test", "test", "person").await?;
+	assert_eq!(lvs.len(), 1);
+	assert_eq!(lvs[0].what, Value::from(th));
+	txn.cancel().await?;
+	//
+	Ok(())
+}
+
+#[tokio::test]
+async fn kill_statement_unset_param() -> Result<(), Error> {
+	let sql = "KILL $id";
+	let dbs = Datastore::new("memory").await?;
+	let mut ses = Session::for_kv().with_ns("test").with_db("test");
+	ses.rt = true;
+	let res = &mut dbs.execute(&sql, &ses, None, false).await?;
+	assert_eq!(res.len(), 1);
+	//
+	let tmp = res.remove(0).result;
+	assert!(matches!(tmp, Err(Error::KillStatementParam { name }) if name == "$id"));
+	//
+	Ok(())
+}
+
+#[tokio::test]
+async fn live_statement_nolive_override() -> Result<(), Error> {
+	let sql = "
+		DEFINE TABLE person NOLIVE;
+		LIVE SELECT * FROM person;
+	";
+	let dbs = Datastore::new("memory").await?;
+	let mut ses = Session::for_kv().with_ns("test").with_db("test");
+	ses.rt = true;
+	let res = &mut dbs.execute(&sql, &ses, None, false).await?;
+	assert_eq!(res.len(), 2);
+	//
+	let tmp = res.remove(0).result;
+	assert!(tmp.is_ok());
+	//
+	let tmp = res.remove(0).result;
+	assert!(tmp.is_ok());
+	//
+	let mut txn = dbs.transaction(false, false).await?;
+	let lvs = txn.all_lv("test", "test", "person").await?;
+	assert_eq!(lvs.len(), 1);
+	txn.cancel().await?;
+	//
+	Ok(())
+}
@@ -9,6 +9,9 @@ mod version;
 
 pub use config::CF;
 
+#[cfg(test)]
+pub use config::Config;
+
 use crate::cnf::LOGO;
 use clap::{Arg, Command};
 
@@ -1,3 +1,5 @@
+use std::time::Duration;
+
 pub const LOGO: &str = "
  .d8888b.                                             888 8888888b.  888888b.
 d88P  Y88b                                            888 888  'Y88b 888  '88b
@@ -22,3 +24,48 @@ pub const APP_ENDPOINT: &str = "https://app.surrealdb.com";
 
 // Specifies how many concurrent jobs can be buffered in the worker channel.
 pub const MAX_CONCURRENT_CALLS: usize = 24;
+
+// Specifies how many requests an RPC connection can be processing at once.
+pub const MAX_CONCURRENT_REQUESTS: usize = 64;
+
+// Specifies how many requests an unprivileged RPC connection can be processing at once.
+pub const MAX_SCOPE_CONCURRENT_REQUESTS: usize = 16;
+
+// Specifies the frequency with which ping messages should be sent to the client.
+pub const WEBSOCKET_PING_FREQUENCY: Duration = Duration::from_secs(5);
+
+// Specifies how long a client can go without responding before it is disconnected.
+pub const WEBSOCKET_PING_TIMEOUT: Duration = Duration::from_secs(30);
+
+// Specifies how long a message can take to be sent to a client before it is disconnected.
+pub const WEBSOCKET_SEND_TIMEOUT: Duration = Duration::from_secs(10);
+
+// Specifies how long an RPC connection can be idle before it is disconnected.
+pub const WEBSOCKET_IDLE_TIMEOUT: Duration = Duration::from_secs(3600);
+
+// Specifies how long an unprivileged RPC connection can be idle before it is disconnected.
+pub const WEBSOCKET_SCOPE_IDLE_TIMEOUT: Duration = Duration::from_secs(900);
+
+// Specifies how many live queries an RPC connection can have active at once.
+pub const MAX_LIVE_QUERIES: usize = 1000;
+
+// Specifies how many live queries an unprivileged RPC connection can have active at once.
+pub const MAX_SCOPE_LIVE_QUERIES: usize = 100;
+
+// Specifies how many live queries a scope user can have active at once across all RPC connections.
+pub const MAX_SCOPE_USER_LIVE_QUERIES: usize = 250;
+
+// Specifies how many denied live queries on an RPC connection trigger an alert.
+pub const MAX_LIVE_QUERY_DENIALS: usize = 10;
+
+// Specifies how many live queries an RPC connection can start per second.
+pub const MAX_LIVE_QUERY_RATE: usize = 100;
+
+// Specifies how many live queries an unprivileged RPC connection can start per second.
+pub const MAX_SCOPE_LIVE_QUERY_RATE: usize = 10;
+
+// Specifies how many times killing the live queries of an RPC connection is retried after failing.
+pub const LIVE_QUERY_KILL_RETRIES: usize = 5;
+
+// Specifies how long to wait before retrying to kill the live queries of an RPC connection.
+pub const LIVE_QUERY_KILL_RETRY_INTERVAL: Duration = Duration::from_secs(5);
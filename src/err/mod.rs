@@ -23,6 +23,24 @@ pub enum Error {
 	#[error("There was a problem with authentication")]
 	InvalidAuth,
 
+	#[error("The maximum number of live queries for this connection has been reached")]
+	TooManyLiveQueries,
+
+	#[error("The maximum number of live queries for this scope user has been reached")]
+	TooManyScopeLiveQueries,
+
+	#[error("Live queries are being started too quickly, try again later")]
+	LiveQueryRateLimit,
+
+	#[error("The query starts more live queries than this connection can start per second")]
+	LiveQueryRateExceeded,
+
+	#[error("The live query was not started on this connection")]
+	LiveQueryNotFound,
+
+	#[error("Live queries can only be started in the namespace and database of this connection")]
+	LiveQueryDatabase,
+
 	#[error("There was a problem with the database: {0}")]
 	Db(#[from] DbError),
 
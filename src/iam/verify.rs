@@ -79,6 +79,15 @@ static DUD: Lazy<Validation> = Lazy::new(|| {
 	validation
 });
 
+pub fn expiry(auth: &str) -> Option<i64> {
+	// Retrieve just the auth data
+	let (_, auth) = auth.split_once(' ')?;
+	// Decode the token without verifying
+	let token = decode::<Claims>(auth, &KEY, &DUD).ok()?;
+	// Return the token expiry time
+	Some(token.claims.exp)
+}
+
 pub async fn basic(session: &mut Session, auth: String) -> Result<(), Error> {
 	// Retrieve just the auth data
 	if let Some((_, auth)) = auth.split_once(' ') {
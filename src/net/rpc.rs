@@ -1,54 +1,122 @@
 use crate::cli::CF;
 use crate::cnf::MAX_CONCURRENT_CALLS;
+use crate::cnf::MAX_CONCURRENT_REQUESTS;
+use crate::cnf::MAX_LIVE_QUERIES;
+use crate::cnf::MAX_LIVE_QUERY_DENIALS;
+use crate::cnf::MAX_LIVE_QUERY_RATE;
+use crate::cnf::MAX_SCOPE_CONCURRENT_REQUESTS;
+use crate::cnf::MAX_SCOPE_LIVE_QUERIES;
+use crate::cnf::MAX_SCOPE_LIVE_QUERY_RATE;
+use crate::cnf::WEBSOCKET_IDLE_TIMEOUT;
+use crate::cnf::WEBSOCKET_PING_FREQUENCY;
+use crate::cnf::WEBSOCKET_PING_TIMEOUT;
+use crate::cnf::WEBSOCKET_SCOPE_IDLE_TIMEOUT;
+use crate::cnf::WEBSOCKET_SEND_TIMEOUT;
 use crate::dbs::DB;
 use crate::err::Error;
 use crate::net::session;
 use crate::rpc::args::Take;
+use crate::rpc::lives;
 use crate::rpc::paths::{ID, METHOD, PARAMS};
 use crate::rpc::res::Failure;
 use crate::rpc::res::Response;
+use chrono::Utc;
 use futures::{SinkExt, StreamExt};
 use std::collections::BTreeMap;
 use std::sync::Arc;
+use std::sync::Mutex;
+use std::time::Duration;
+use std::time::Instant;
 use surrealdb::channel;
 use surrealdb::channel::Sender;
+use surrealdb::sql::statements::LiveStatement;
 use surrealdb::sql::Object;
+use surrealdb::sql::Part;
+use surrealdb::sql::Query;
+use surrealdb::sql::Statement;
 use surrealdb::sql::Strand;
+use surrealdb::sql::Uuid;
 use surrealdb::sql::Value;
+use surrealdb::Auth;
+use surrealdb::Error as DbError;
+use surrealdb::Response as DbResponse;
 use surrealdb::Session;
+use tokio::sync::OwnedSemaphorePermit;
 use tokio::sync::RwLock;
+use tokio::sync::Semaphore;
 use warp::ws::{Message, WebSocket, Ws};
 use warp::Filter;
 
+const LOG: &str = "surrealdb::rpc";
+
 pub fn config() -> impl Filter<Extract = impl warp::Reply, Error = warp::Rejection> + Clone {
 	warp::path("rpc")
 		.and(warp::path::end())
 		.and(warp::ws())
 		.and(session::build())
-		.map(|ws: Ws, session: Session| ws.on_upgrade(move |ws| socket(ws, session)))
+		.and(warp::header::optional::<String>("authorization"))
+		.map(|ws: Ws, session: Session, auth: Option<String>| {
+			ws.on_upgrade(move |ws| socket(ws, session, auth))
+		})
 }
 
-async fn socket(ws: WebSocket, session: Session) {
-	let rpc = Rpc::new(session);
+async fn socket(ws: WebSocket, session: Session, auth: Option<String>) {
+	let rpc = Rpc::new(session, auth);
 	Rpc::serve(rpc, ws).await
 }
 
+// The timeouts of a connection, which are checked without holding the lock
+struct Status {
+	expiry: Option<i64>,
+	idle: Duration,
+}
+
+impl Status {
+	// Check if the scope token for the connection has expired
+	fn expired(&self) -> bool {
+		match self.expiry {
+			Some(exp) => Utc::now().timestamp() > exp,
+			None => false,
+		}
+	}
+}
+
 pub struct Rpc {
 	session: Session,
 	vars: BTreeMap<String, Value>,
+	lives: Arc<lives::Socket>,
+	status: Arc<Mutex<Status>>,
+	calls: Arc<Semaphore>,
 }
 
 impl Rpc {
 	// Instantiate a new RPC
-	pub fn new(mut session: Session) -> Arc<RwLock<Rpc>> {
+	pub fn new(mut session: Session, auth: Option<String>) -> Arc<RwLock<Rpc>> {
 		// Create a new RPC variables store
 		let vars = BTreeMap::new();
+		// Create a new RPC live queries store
+		let lives = lives::Socket::new();
+		// Create the request slots for this connection
+		let calls = Rpc::requests(&session.au);
+		// Store the expiry of a scope token and the idle timeout
+		let status = Arc::new(Mutex::new(Status {
+			expiry: match session.au.as_ref() {
+				Auth::Sc(_, _, _) => auth.as_deref().and_then(crate::iam::verify::expiry),
+				_ => None,
+			},
+			idle: Rpc::idle(&session.au),
+		}));
 		// Enable real-time live queries
 		session.rt = true;
+		// Identify the connection if no id was given
+		session.id.get_or_insert_with(|| Uuid::new().to_raw());
 		// Create and store the Rpc connection
 		Arc::new(RwLock::new(Rpc {
 			session,
 			vars,
+			lives,
+			status,
+			calls,
 		}))
 	}
 
@@ -58,22 +126,187 @@ impl Rpc {
 		let (chn, mut rcv) = channel::new(MAX_CONCURRENT_CALLS);
 		// Split the socket into send and recv
 		let (mut wtx, mut wrx) = ws.split();
+		// Get the connection id for logging
+		let conn = rpc.read().await.conn().to_owned();
+		// Get the timeouts of this connection
+		let status = rpc.read().await.status.clone();
 		// Send messages to the client
-		tokio::task::spawn(async move {
+		let mut writer = tokio::task::spawn(async move {
 			while let Some(res) = rcv.next().await {
-				wtx.send(res).await.unwrap();
+				// Stop sending if the socket has failed or stalled
+				match tokio::time::timeout(WEBSOCKET_SEND_TIMEOUT, wtx.send(res)).await {
+					Ok(Ok(_)) => (),
+					Ok(Err(e)) => {
+						warn!(target: LOG, "Failed to send message on connection {}: {}", conn, e);
+						break;
+					}
+					Err(_) => {
+						warn!(target: LOG, "Timed out sending message on connection {}", conn);
+						break;
+					}
+				}
 			}
 		});
+		// Send pings to the client periodically
+		let mut interval = tokio::time::interval(WEBSOCKET_PING_FREQUENCY);
+		// Store when the client last sent a message
+		let mut seen = Instant::now();
+		// Store when the client last sent a request
+		let mut used = Instant::now();
 		// Get messages from the client
-		while let Some(msg) = wrx.next().await {
-			if let Ok(msg) = msg {
-				if msg.is_text() {
-					tokio::task::spawn(Rpc::call(rpc.clone(), msg, chn.clone()));
+		loop {
+			tokio::select! {
+				// Disconnect clients which can no longer be sent messages
+				_ = &mut writer => break,
+				_ = interval.tick() => {
+					// Disconnect clients which have stopped responding
+					if seen.elapsed() > WEBSOCKET_PING_TIMEOUT {
+						break;
+					}
+					// Check the timeouts without holding the lock on the connection
+					let (expired, idle) = {
+						let status = status.lock().unwrap();
+						(status.expired(), status.idle)
+					};
+					// Disconnect clients whose scope token has expired
+					if expired {
+						let _ = chn.send(Message::close_with(1008u16, "Token has expired")).await;
+						break;
+					}
+					// Disconnect clients which have stopped sending requests
+					if used.elapsed() > idle {
+						let _ = chn.send(Message::close()).await;
+						break;
+					}
+					// Send a ping alongside the other messages
+					if chn.send(Message::ping(vec![])).await.is_err() {
+						break;
+					}
 				}
+				msg = wrx.next() => match msg {
+					Some(Ok(msg)) => {
+						seen = Instant::now();
+						if msg.is_text() {
+							used = Instant::now();
+							tokio::task::spawn(Rpc::call(rpc.clone(), msg, chn.clone()));
+						}
+					}
+					Some(Err(_)) => continue,
+					None => break,
+				},
+			}
+		}
+		// Take the live queries of this connection
+		let (session, lives, socket) = {
+			let rpc = rpc.read().await;
+			(rpc.session.clone(), rpc.lives.close(), rpc.lives.clone())
+		};
+		// Remove the live queries of this connection
+		let conn = session.id.as_deref().unwrap_or_default();
+		debug!(target: LOG, "Closing connection {} with {} live queries", conn, lives.len());
+		if let Err(e) = Rpc::kill_many(&session, &socket, lives).await {
+			error!(target: LOG, "Unable to kill live queries for connection {}: {}", conn, e);
+		}
+	}
+
+	// Get the id of this connection
+	fn conn(&self) -> &str {
+		self.session.id.as_deref().unwrap_or_default()
+	}
+
+	// Log a live query change on a connection
+	fn log_live(session: &Session, event: &str, id: &Uuid, what: &Value, active: usize) {
+		debug!(
+			target: LOG,
+			"{} live query {} on {} for connection {} in {}/{} ({} active)",
+			event,
+			id.to_raw(),
+			what,
+			session.id.as_deref().unwrap_or_default(),
+			session.ns.as_deref().unwrap_or_default(),
+			session.db.as_deref().unwrap_or_default(),
+			active
+		);
+	}
+
+	// Log a denied live query on a connection
+	fn log_denied(session: &Session, socket: &lives::Socket, what: &Value, err: &DbError) {
+		// Count the denied live queries
+		let denied = socket.deny();
+		let conn = session.id.as_deref().unwrap_or_default();
+		warn!(
+			target: LOG,
+			"Denied live query on {} for connection {} with scope {} in {}/{}: {}",
+			what,
+			conn,
+			session.sc.as_deref().unwrap_or_default(),
+			session.ns.as_deref().unwrap_or_default(),
+			session.db.as_deref().unwrap_or_default(),
+			err
+		);
+		// Alert when a connection keeps being denied
+		if denied % MAX_LIVE_QUERY_DENIALS == 0 {
+			error!(target: LOG, "Connection {} has had {} live queries denied", conn, denied);
+		}
+	}
+
+	// Check if this connection can kill live queries started on other connections
+	fn admin(&self) -> bool {
+		matches!(self.session.au.as_ref(), Auth::Kv | Auth::Ns(_))
+	}
+
+	// Get the idle timeout for an authentication level
+	fn idle(au: &Auth) -> Duration {
+		match au {
+			Auth::Kv | Auth::Ns(_) | Auth::Db(_, _) => WEBSOCKET_IDLE_TIMEOUT,
+			_ => WEBSOCKET_SCOPE_IDLE_TIMEOUT,
+		}
+	}
+
+	// Get the live query limit for this connection
+	fn limit(&self) -> usize {
+		match self.session.au.as_ref() {
+			Auth::Kv | Auth::Ns(_) | Auth::Db(_, _) => MAX_LIVE_QUERIES,
+			_ => MAX_SCOPE_LIVE_QUERIES,
+		}
+	}
+
+	// Get the live query rate for this connection
+	fn rate(&self) -> usize {
+		match self.session.au.as_ref() {
+			Auth::Kv | Auth::Ns(_) | Auth::Db(_, _) => MAX_LIVE_QUERY_RATE,
+			_ => MAX_SCOPE_LIVE_QUERY_RATE,
+		}
+	}
+
+	// Take live query tokens for this connection
+	fn throttle(&self, count: usize) -> bool {
+		self.lives.throttle(count, self.rate() as f64)
+	}
+
+	// Create the request slots for an authentication level
+	fn requests(au: &Auth) -> Arc<Semaphore> {
+		match au {
+			Auth::Kv | Auth::Ns(_) | Auth::Db(_, _) => {
+				Arc::new(Semaphore::new(MAX_CONCURRENT_REQUESTS))
 			}
+			_ => Arc::new(Semaphore::new(MAX_SCOPE_CONCURRENT_REQUESTS)),
+		}
+	}
+
+	// Resize the request slots if the authentication level changed
+	fn resize(&mut self, old: &Auth) {
+		let privileged = |au: &Auth| matches!(au, Auth::Kv | Auth::Ns(_) | Auth::Db(_, _));
+		if privileged(old) != privileged(self.session.au.as_ref()) {
+			self.calls = Rpc::requests(&self.session.au);
 		}
 	}
 
+	// Reserve a request slot on this connection
+	fn enter(&self) -> Option<OwnedSemaphorePermit> {
+		self.calls.clone().try_acquire_owned().ok()
+	}
+
 	// Call RPC methods from the WebSocket
 	async fn call(rpc: Arc<RwLock<Rpc>>, msg: Message, chn: Sender<Message>) {
 		// Clone the RPC
@@ -104,6 +337,18 @@ impl Rpc {
 			Value::Array(v) => v,
 			_ => return Response::failure(id, Failure::INVALID_REQUEST).send(chn).await,
 		};
+		// Disconnect clients whose scope token has expired
+		let expired = rpc.read().await.status.lock().unwrap().expired();
+		if expired {
+			Response::failure(id, Failure::from(Error::InvalidAuth)).send(chn.clone()).await;
+			let _ = chn.send(Message::close_with(1008u16, "Token has expired")).await;
+			return;
+		}
+		// Check the concurrent request limit
+		let _permit = match rpc.read().await.enter() {
+			Some(v) => v,
+			None => return Response::failure(id, Failure::BUSY).send(chn).await,
+		};
 		// Match the method to a function
 		let res = match &method[..] {
 			"ping" => Ok(Value::True),
@@ -111,8 +356,12 @@ impl Rpc {
 				0 => rpc.read().await.info().await,
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
+			"session" => match params.len() {
+				0 => rpc.read().await.session().await,
+				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
+			},
 			"use" => match params.take_two() {
-				(Value::Strand(ns), Value::Strand(db)) => rpc.write().await.yuse(ns, db).await,
+				(Value::Strand(ns), Value::Strand(db)) => Rpc::yuse(&rpc, ns, db).await,
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
 			"signup" => match params.take_one() {
@@ -124,20 +373,31 @@ impl Rpc {
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
 			"invalidate" => match params.len() {
-				0 => rpc.write().await.invalidate().await,
+				0 => Rpc::invalidate(&rpc).await,
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
 			"authenticate" => match params.take_one() {
-				Value::None => rpc.write().await.invalidate().await,
-				Value::Strand(v) => rpc.write().await.authenticate(v).await,
+				Value::None => Rpc::invalidate(&rpc).await,
+				Value::Strand(v) => Rpc::authenticate(&rpc, v).await,
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
 			"kill" => match params.take_one() {
-				v if v.is_uuid() => rpc.read().await.kill(v).await,
+				Value::None => Rpc::kill_all(&rpc).await,
+				v if v.is_uuid() => Rpc::kill(&rpc, v).await,
+				v if v.is_strand() => Rpc::kill_table(&rpc, v).await,
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
-			"live" => match params.take_one() {
-				v if v.is_strand() => rpc.read().await.live(v).await,
+			"live" => match params.take_two() {
+				(v, f) if v.is_thing() => Rpc::live(&rpc, v, f.is_true()).await,
+				(v, f) if v.is_strand() => Rpc::live(&rpc, v, f.is_true()).await,
+				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
+			},
+			"lives" => match params.len() {
+				0 => rpc.read().await.lives().await,
+				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
+			},
+			"active" => match params.len() {
+				0 => rpc.read().await.active().await,
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
 			"let" => match params.take_two() {
@@ -149,8 +409,8 @@ impl Rpc {
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
 			"query" => match params.take_two() {
-				(Value::Strand(s), o) if o.is_none() => rpc.read().await.query(s).await,
-				(Value::Strand(s), Value::Object(o)) => rpc.read().await.query_with(s, o).await,
+				(Value::Strand(s), o) if o.is_none() => Rpc::query(&rpc, s).await,
+				(Value::Strand(s), Value::Object(o)) => Rpc::query_with(&rpc, s, o).await,
 				_ => return Response::failure(id, Failure::INVALID_PARAMS).send(chn).await,
 			},
 			"select" => match params.take_one() {
@@ -194,7 +454,7 @@ impl Rpc {
 		// Return the final response
 		match res {
 			Ok(v) => Response::success(id, v).send(chn).await,
-			Err(e) => Response::failure(id, Failure::custom(e.to_string())).send(chn).await,
+			Err(e) => Response::failure(id, Failure::from(e)).send(chn).await,
 		}
 	}
 
@@ -202,9 +462,23 @@ impl Rpc {
 	// Methods for authentication
 	// ------------------------------
 
-	async fn yuse(&mut self, ns: Strand, db: Strand) -> Result<Value, Error> {
-		self.session.ns = Some(ns.0);
-		self.session.db = Some(db.0);
+	async fn yuse(rpc: &RwLock<Rpc>, ns: Strand, db: Strand) -> Result<Value, Error> {
+		// Switch the database while holding the lock
+		let (session, lives, socket) = {
+			let mut rpc = rpc.write().await;
+			let session = rpc.session.clone();
+			// Take live queries which are not in the new database
+			let lives =
+				match session.ns.as_ref() != Some(&ns.0) || session.db.as_ref() != Some(&db.0) {
+					true => rpc.lives.take(|_, _| true),
+					false => Vec::new(),
+				};
+			rpc.session.ns = Some(ns.0);
+			rpc.session.db = Some(db.0);
+			(session, lives, rpc.lives.clone())
+		};
+		// Kill the live queries without holding the lock, as failures are retried in the background
+		let _ = Rpc::kill_many(&session, &socket, lives).await;
 		Ok(Value::None)
 	}
 
@@ -216,13 +490,55 @@ impl Rpc {
 		crate::iam::signin::signin(vars).await.map(Into::into).map_err(Into::into)
 	}
 
-	async fn invalidate(&mut self) -> Result<Value, Error> {
-		crate::iam::clear::clear(&mut self.session).await?;
+	async fn invalidate(rpc: &RwLock<Rpc>) -> Result<Value, Error> {
+		// Clear the session while holding the lock
+		let (session, lives, socket) = {
+			let mut rpc = rpc.write().await;
+			let session = rpc.session.clone();
+			crate::iam::clear::clear(&mut rpc.session).await?;
+			rpc.resize(&session.au);
+			*rpc.status.lock().unwrap() = Status {
+				expiry: None,
+				idle: Rpc::idle(&rpc.session.au),
+			};
+			// Take the live queries of the previous user
+			(session, rpc.lives.take(|_, _| true), rpc.lives.clone())
+		};
+		// Kill the live queries without holding the lock, as failures are retried in the background
+		let _ = Rpc::kill_many(&session, &socket, lives).await;
 		Ok(Value::None)
 	}
 
-	async fn authenticate(&mut self, token: Strand) -> Result<Value, Error> {
-		crate::iam::verify::token(&mut self.session, token.0).await?;
+	async fn authenticate(rpc: &RwLock<Rpc>, token: Strand) -> Result<Value, Error> {
+		// Authenticate the session while holding the lock
+		let (session, lives, socket) = {
+			let mut rpc = rpc.write().await;
+			// Authenticate a copy of the current session
+			let mut session = rpc.session.clone();
+			crate::iam::verify::token(&mut session, token.0.clone()).await?;
+			// Take live queries which were started by a different user or database
+			let lives = match session.au != rpc.session.au
+				|| session.sd != rpc.session.sd
+				|| session.ns != rpc.session.ns
+				|| session.db != rpc.session.db
+			{
+				true => rpc.lives.take(|_, _| true),
+				false => Vec::new(),
+			};
+			// Store the authenticated session
+			let session = std::mem::replace(&mut rpc.session, session);
+			rpc.resize(&session.au);
+			*rpc.status.lock().unwrap() = Status {
+				expiry: match rpc.session.au.as_ref() {
+					Auth::Sc(_, _, _) => crate::iam::verify::expiry(&token.0),
+					_ => None,
+				},
+				idle: Rpc::idle(&rpc.session.au),
+			};
+			(session, lives, rpc.lives.clone())
+		};
+		// Kill the live queries without holding the lock, as failures are retried in the background
+		let _ = Rpc::kill_many(&session, &socket, lives).await;
 		Ok(Value::None)
 	}
 
@@ -245,6 +561,20 @@ impl Rpc {
 		Ok(res)
 	}
 
+	async fn session(&self) -> Result<Value, Error> {
+		// Output the session values, without the auth data
+		let res = Value::from(map! {
+			String::from("ip") => self.session.ip.to_owned().into(),
+			String::from("or") => self.session.or.to_owned().into(),
+			String::from("id") => self.session.id.to_owned().into(),
+			String::from("ns") => self.session.ns.to_owned().into(),
+			String::from("db") => self.session.db.to_owned().into(),
+			String::from("sc") => self.session.sc.to_owned().into(),
+		});
+		// Return the result to the client
+		Ok(res)
+	}
+
 	// ------------------------------
 	// Methods for setting variables
 	// ------------------------------
@@ -268,80 +598,467 @@ impl Rpc {
 	// Methods for live queries
 	// ------------------------------
 
-	async fn kill(&self, id: Value) -> Result<Value, Error> {
-		// Get a database reference
-		let kvs = DB.get().unwrap();
-		// Get local copy of options
-		let opt = CF.get().unwrap();
-		// Specify the SQL query string
-		let sql = "KILL $id";
-		// Specify the query paramaters
-		let var = Some(map! {
-			String::from("id") => id,
-			=> &self.vars
-		});
-		// Execute the query on the database
-		let mut res = kvs.execute(sql, &self.session, var, opt.strict).await?;
-		// Extract the first query result
-		let res = res.remove(0).result?;
+	async fn kill(rpc: &RwLock<Rpc>, id: Value) -> Result<Value, Error> {
+		// Take the live query while holding the lock
+		let (session, lives, socket, admin) = {
+			let rpc = rpc.read().await;
+			let lives = match &id {
+				Value::Uuid(v) => rpc.lives.take(|k, _| k == v),
+				_ => Vec::new(),
+			};
+			(rpc.session.clone(), lives, rpc.lives.clone(), rpc.admin())
+		};
+		// Kill the live query without holding the lock
+		match (&id, lives.is_empty()) {
+			// Kill a live query started on this connection
+			(_, false) => Rpc::kill_many(&session, &socket, lives).await,
+			// Privileged users can kill live queries started on other connections
+			(Value::Uuid(v), true) if admin => {
+				// Get a database reference
+				let kvs = DB.get().unwrap();
+				// Get local copy of options
+				let opt = CF.get().unwrap();
+				// Specify the SQL query string
+				let sql = format!("KILL {}", v);
+				// Execute the query on the database
+				let mut res = kvs.execute(&sql, &session, None, opt.strict).await?;
+				// Extract the first query result
+				res.remove(0).result?;
+				// Remove the live query from the connection which started it
+				Rpc::forget(&session, v);
+				// Return the result to the client
+				Ok(Rpc::killed(&socket, vec![Value::from(v.clone())]))
+			}
+			// Only kill live queries started on this connection
+			_ => Err(Error::LiveQueryNotFound),
+		}
+	}
+
+	async fn kill_all(rpc: &RwLock<Rpc>) -> Result<Value, Error> {
+		// Take all of the live queries while holding the lock
+		let (session, lives, socket) = {
+			let rpc = rpc.read().await;
+			(rpc.session.clone(), rpc.lives.take(|_, _| true), rpc.lives.clone())
+		};
+		// Kill the live queries without holding the lock
+		Rpc::kill_many(&session, &socket, lives).await
+	}
+
+	async fn kill_table(rpc: &RwLock<Rpc>, tb: Value) -> Result<Value, Error> {
+		// Specify the live query table
+		let tb = tb.as_strand().0;
+		// Take the live queries on the table while holding the lock
+		let (session, lives, socket) = {
+			let rpc = rpc.read().await;
+			(rpc.session.clone(), rpc.lives.take(|_, v| lives::on(v, &tb)), rpc.lives.clone())
+		};
+		// Kill the live queries without holding the lock
+		Rpc::kill_many(&session, &socket, lives).await
+	}
+
+	async fn kill_many(
+		session: &Session,
+		socket: &lives::Socket,
+		lives: Vec<(Uuid, Value)>,
+	) -> Result<Value, Error> {
+		// Store the live queries which were killed
+		let mut killed = Vec::new();
+		// Check if there are any live queries
+		if lives.is_empty() {
+			return Ok(Rpc::killed(socket, killed));
+		}
+		// Kill the live queries on the database
+		let ids = lives.iter().map(|(id, _)| id.clone()).collect::<Vec<Uuid>>();
+		let res = match lives::kill(session, &ids).await {
+			Ok(v) => v,
+			Err(e) => {
+				warn!(target: LOG, "Unable to kill {} live queries: {}", lives.len(), e);
+				Rpc::reap(session, socket, lives);
+				return Err(e.into());
+			}
+		};
+		// Store the first failed query result
+		let mut err = None;
+		// Store the live queries which could not be killed
+		let mut failed = Vec::new();
+		// Check each of the query results
+		for ((id, tb), v) in lives.into_iter().zip(res) {
+			match v {
+				// Log the killed live query
+				Ok(()) => {
+					Rpc::log_live(session, "Killed", &id, &tb, socket.len());
+					killed.push(Value::from(id));
+				}
+				// Retry killing the live query in the background
+				Err(e) => {
+					warn!(target: LOG, "Unable to kill live query {}: {}", id.to_raw(), e);
+					err.get_or_insert(e);
+					failed.push((id, tb));
+				}
+			}
+		}
+		// Hand over the live queries which could not be killed
+		Rpc::reap(session, socket, failed);
 		// Return the result to the client
-		Ok(res)
+		match err {
+			Some(e) => Err(e.into()),
+			None => Ok(Rpc::killed(socket, killed)),
+		}
 	}
 
-	async fn live(&self, tb: Value) -> Result<Value, Error> {
-		// Get a database reference
-		let kvs = DB.get().unwrap();
-		// Get local copy of options
-		let opt = CF.get().unwrap();
-		// Specify the SQL query string
-		let sql = "LIVE SELECT * FROM $tb";
-		// Specify the query paramaters
-		let var = Some(map! {
-			String::from("tb") => tb.make_table(),
-			=> &self.vars
-		});
+	fn killed(socket: &lives::Socket, killed: Vec<Value>) -> Value {
+		// Output the killed and remaining live queries
+		Value::from(map! {
+			String::from("id") => Value::from(killed),
+			String::from("lives") => Value::from(socket.len()),
+		})
+	}
+
+	fn forget(session: &Session, id: &Uuid) {
+		// Find the connection which started the live query
+		if let Some(owner) = lives::owner(id) {
+			// Remove the live query from the connection
+			for (id, tb) in owner.take(|v, _| v == id) {
+				info!(
+					target: LOG,
+					"Killed live query {} on {} started on another connection, for connection {}",
+					id.to_raw(),
+					tb,
+					session.id.as_deref().unwrap_or_default()
+				);
+			}
+		}
+	}
+
+	fn reap(session: &Session, socket: &lives::Socket, lives: Vec<(Uuid, Value)>) {
+		// Check if there are any live queries
+		if lives.is_empty() {
+			return;
+		}
+		// Log the live queries which are being handed over
+		for (id, tb) in lives.iter() {
+			Rpc::log_live(session, "Reaping", id, tb, socket.len());
+		}
+		// Retry killing the live queries in the background
+		lives::reap(session.clone(), lives.into_iter().map(|(id, _)| id).collect());
+	}
+
+	async fn live(rpc: &RwLock<Rpc>, tb: Value, force: bool) -> Result<Value, Error> {
+		// Specify the live query target
+		let tb = tb.make_table_or_thing();
+		// Specify the live query on the target
+		let mut ast = surrealdb::sql::parse("LIVE SELECT * FROM $tb")?;
+		if let Some(Statement::Live(v)) = ast.0 .0.first_mut() {
+			v.what = tb.clone();
+			// Reuse an identical live query on this connection
+			if !force {
+				if let Some(id) = rpc.read().await.lives.find(v) {
+					return Ok(id.into());
+				}
+			}
+		}
 		// Execute the query on the database
-		let mut res = kvs.execute(sql, &self.session, var, opt.strict).await?;
+		let mut res = Rpc::execute(rpc, ast, BTreeMap::new()).await?;
 		// Extract the first query result
-		let res = res.remove(0).result?;
+		Ok(res.remove(0).result?)
+	}
+
+	async fn lives(&self) -> Result<Value, Error> {
+		// Output the live queries on this connection
+		let res = self
+			.lives
+			.list()
+			.into_iter()
+			.map(|(id, tb, query)| {
+				Value::from(map! {
+					String::from("id") => Value::from(id),
+					String::from("query") => Value::from(query),
+					String::from("what") => tb,
+				})
+			})
+			.collect::<Vec<Value>>()
+			.into();
 		// Return the result to the client
 		Ok(res)
 	}
 
+	async fn active(&self) -> Result<Value, Error> {
+		// Only root users can view every namespace
+		if !self.session.au.is_kv() {
+			return Err(Error::InvalidAuth);
+		}
+		// Return the result to the client
+		Ok(lives::summary())
+	}
+
 	// ------------------------------
 	// Methods for querying
 	// ------------------------------
 
-	async fn query(&self, sql: Strand) -> Result<Value, Error> {
-		// Get a database reference
-		let kvs = DB.get().unwrap();
-		// Get local copy of options
-		let opt = CF.get().unwrap();
-		// Specify the query paramaters
-		let var = Some(self.vars.clone());
+	async fn query(rpc: &RwLock<Rpc>, sql: Strand) -> Result<Value, Error> {
+		// Parse the SQL query string
+		let ast = surrealdb::sql::parse(&sql)?;
 		// Execute the query on the database
-		let res = kvs.execute(&sql, &self.session, var, opt.strict).await?;
+		let res = Rpc::execute(rpc, ast, BTreeMap::new()).await?;
 		// Extract the first query result
 		let res = res.into_iter().collect::<Vec<Value>>().into();
 		// Return the result to the client
 		Ok(res)
 	}
 
-	async fn query_with(&self, sql: Strand, mut vars: Object) -> Result<Value, Error> {
-		// Get a database reference
-		let kvs = DB.get().unwrap();
-		// Get local copy of options
-		let opt = CF.get().unwrap();
-		// Specify the query paramaters
-		let var = Some(mrg! { vars.0, &self.vars });
+	async fn query_with(rpc: &RwLock<Rpc>, sql: Strand, vars: Object) -> Result<Value, Error> {
+		// Parse the SQL query string
+		let ast = surrealdb::sql::parse(&sql)?;
 		// Execute the query on the database
-		let res = kvs.execute(&sql, &self.session, var, opt.strict).await?;
+		let res = Rpc::execute(rpc, ast, vars.0).await?;
 		// Extract the first query result
 		let res = res.into_iter().collect::<Vec<Value>>().into();
 		// Return the result to the client
 		Ok(res)
 	}
 
+	async fn execute(
+		rpc: &RwLock<Rpc>,
+		mut ast: Query,
+		mut vars: BTreeMap<String, Value>,
+	) -> Result<Vec<DbResponse>, Error> {
+		// Check the connection while holding a read lock, as the live queries have their own locks
+		let (session, vars, socket, lives, kills, count) = {
+			let rpc = rpc.read().await;
+			// Specify the query paramaters
+			let vars = mrg! { vars, &rpc.vars };
+			// Find the live queries which this query starts and kills
+			let (lives, kills, count) =
+				Rpc::scan(&mut ast, &vars, &rpc.session, &rpc.lives, rpc.admin())?;
+			if !lives.is_empty() {
+				// Reject queries which start more live queries than the rate limit ever allows
+				if lives.len() > rpc.rate() {
+					return Err(Error::LiveQueryRateExceeded);
+				}
+				// Reserve a slot for each live query within the live query limits
+				let stms = lives.iter().map(|(_, v)| v.clone()).collect::<Vec<_>>();
+				rpc.lives.reserve(&stms, rpc.limit(), lives::identity(&rpc.session))?;
+				// Check the live query rate limit
+				if !rpc.throttle(lives.len()) {
+					for (_, stm) in lives.iter() {
+						rpc.lives.abort(&stm.id);
+					}
+					return Err(Error::LiveQueryRateLimit);
+				}
+			}
+			(rpc.session.clone(), vars, rpc.lives.clone(), lives, kills, count)
+		};
+		// Get a database reference
+		let kvs = DB.get().unwrap();
+		// Get local copy of options
+		let opt = CF.get().unwrap();
+		// Execute the query without holding the lock
+		let mut res = match kvs.process(ast, &session, Some(vars), opt.strict).await {
+			Ok(v) => v,
+			Err(e) => {
+				// Release the reserved slots
+				for (_, stm) in lives.iter() {
+					socket.abort(&stm.id);
+				}
+				return Err(e.into());
+			}
+		};
+		// Store the live queries which were killed meanwhile
+		let mut discarded = Vec::new();
+		// Store the live queries which were started
+		for (
+			pos,
+			LiveStatement {
+				id,
+				what,
+				..
+			},
+		) in lives
+		{
+			// Find the result of the live query, if it was started
+			let out = res.iter_mut().find(|v| matches!(&v.result, Ok(Value::Uuid(v)) if *v == id));
+			match out {
+				// Store the live query on this connection
+				Some(_) if socket.start(&id, &session) => {
+					Rpc::log_live(&session, "Started", &id, &what, socket.len());
+				}
+				// Kill the live query if it was cancelled meanwhile
+				Some(out) => {
+					let conn = session.id.as_deref().unwrap_or_default();
+					debug!(target: LOG, "Discarded live query {} for connection {}", id.to_raw(), conn);
+					out.result = Ok(Value::None);
+					discarded.push((id, what));
+				}
+				// Release the slot of a live query which was not started
+				None => {
+					socket.abort(&id);
+					// Audit live queries which were not permitted, when
+					// the results can be matched to their statement
+					if res.len() != count {
+						continue;
+					}
+					if let Some(Err(
+						e @ (DbError::QueryPermissions
+						| DbError::TablePermissions {
+							..
+						}
+						| DbError::TableLiveQueries {
+							..
+						}),
+					)) = res.get(pos).map(|v| &v.result)
+					{
+						Rpc::log_denied(&session, &socket, &what, e);
+					}
+				}
+			}
+		}
+		// Remove the live queries which were killed, including
+		// live queries which were already removed from the datastore
+		for (pos, id) in kills {
+			let killed = match res.get(pos).map(|v| &v.result) {
+				Some(Ok(_)) => true,
+				Some(Err(DbError::KillStatement {
+					..
+				})) => false,
+				_ => continue,
+			};
+			// Remove the live query from this connection
+			let lives = socket.take(|v, _| *v == id);
+			// Remove a live query started on another connection
+			if lives.is_empty() && killed {
+				Rpc::forget(&session, &id);
+			}
+			for (id, tb) in lives {
+				Rpc::log_live(&session, "Killed", &id, &tb, socket.len());
+			}
+		}
+		// Kill the discarded live queries, as failures are retried in the background
+		let _ = Rpc::kill_many(&session, &socket, discarded).await;
+		// Return the query results
+		Ok(res)
+	}
+
+	// Check the live queries which a query starts and kills, resolving their targets
+	fn scan(
+		ast: &mut Query,
+		vars: &BTreeMap<String, Value>,
+		session: &Session,
+		socket: &lives::Socket,
+		admin: bool,
+	) -> Result<(Vec<(usize, LiveStatement)>, Vec<(usize, Uuid)>, usize), Error> {
+		// Track the database selected within the query
+		let mut ns = session.ns.clone();
+		let mut db = session.db.clone();
+		// Track the parameters defined within the query
+		let mut sets = Vec::new();
+		// Track the position of each statement result
+		let mut pos = 0;
+		// Store the live queries in the query
+		let mut lives = Vec::new();
+		let mut kills = Vec::new();
+		for stm in ast.0 .0.iter_mut() {
+			match stm {
+				Statement::Use(v) => {
+					if v.ns.is_some() {
+						ns = v.ns.clone();
+					}
+					if v.db.is_some() {
+						db = v.db.clone();
+					}
+				}
+				Statement::Set(v) => sets.push(v.name.clone()),
+				Statement::Live(v) => {
+					// Only start live queries in the database of this connection
+					if ns != session.ns || db != session.db {
+						return Err(Error::LiveQueryDatabase);
+					}
+					// Resolve the target so that it is tracked as it is executed,
+					// unless the parameter is defined within the query
+					if !Rpc::shadowed(&v.what, &sets) {
+						if let Some(what) = Rpc::resolve(&v.what, vars) {
+							v.what = what;
+						}
+					}
+					lives.push((pos, v.clone()));
+				}
+				Statement::Kill(v) => {
+					// Only kill live queries in the database of this connection
+					if ns != session.ns || db != session.db {
+						return Err(Error::LiveQueryDatabase);
+					}
+					// Resolve the id so that it is checked as it is executed,
+					// unless the parameter is defined within the query
+					let id = match Rpc::shadowed(&v.id, &sets) {
+						true => None,
+						false => Rpc::resolve(&v.id, vars),
+					};
+					match id.unwrap_or_else(|| v.id.clone()) {
+						// Only kill live queries started on this connection,
+						// unless the user can kill any live query
+						Value::Uuid(id) if admin || socket.contains(&id) => {
+							v.id = Value::Uuid(id.clone());
+							kills.push((pos, id));
+						}
+						Value::Uuid(_) => return Err(Error::LiveQueryNotFound),
+						// Parameters defined within the query can not be checked
+						Value::Param(_) if admin => (),
+						Value::Param(p) => {
+							let parts: &[Part] = &p;
+							match parts {
+								[Part::Field(v)] if !sets.iter().any(|s| s == v.as_str()) => (),
+								_ => return Err(Error::LiveQueryNotFound),
+							}
+						}
+						// Other values are rejected by the database
+						_ => (),
+					}
+				}
+				_ => (),
+			}
+			// Count the statements which output a result
+			match stm {
+				Statement::Option(_)
+				| Statement::Begin(_)
+				| Statement::Cancel(_)
+				| Statement::Commit(_) => (),
+				_ => pos += 1,
+			}
+		}
+		// Results within transactions can not be matched to their statement
+		if ast
+			.iter()
+			.any(|v| matches!(v, Statement::Begin(_) | Statement::Commit(_) | Statement::Cancel(_)))
+		{
+			kills.clear();
+		}
+		Ok((lives, kills, pos))
+	}
+
+	// Check if a value is a parameter defined within the query
+	fn shadowed(val: &Value, sets: &[String]) -> bool {
+		match val {
+			Value::Param(v) => match v.first() {
+				Some(Part::Field(v)) => sets.iter().any(|s| s == v.as_str()),
+				_ => false,
+			},
+			_ => false,
+		}
+	}
+
+	// Resolve a value which is a connection or query parameter
+	fn resolve(val: &Value, vars: &BTreeMap<String, Value>) -> Option<Value> {
+		match val {
+			Value::Param(v) => {
+				let parts: &[Part] = v;
+				match parts {
+					[Part::Field(v)] => vars.get(v.as_str()).cloned(),
+					_ => None,
+				}
+			}
+			_ => None,
+		}
+	}
+
 	// ------------------------------
 	// Methods for selecting
 	// ------------------------------
@@ -490,3 +1207,168 @@ impl Rpc {
 		Ok(res)
 	}
 }
+
+#[cfg(test)]
+mod tests {
+
+	use super::*;
+	use crate::cli::Config;
+	use surrealdb::Datastore;
+
+	async fn rpc() -> Arc<RwLock<Rpc>> {
+		// Setup the datastore and options once
+		if DB.get().is_none() {
+			let _ = DB.set(Datastore::new("memory").await.unwrap());
+		}
+		let _ = CF.set(Config {
+			strict: false,
+			bind: "127.0.0.1:8000".parse().unwrap(),
+			path: String::from("memory"),
+			user: String::from("root"),
+			pass: None,
+			crt: None,
+			key: None,
+		});
+		// Create a root connection on the test database
+		Rpc::new(Session::for_kv().with_ns("test").with_db("test"), None)
+	}
+
+	#[tokio::test]
+	async fn query_live_limit() {
+		let rpc = rpc().await;
+		// Fill every live query slot on the connection
+		{
+			let rpc = rpc.read().await;
+			let lives = (0..rpc.limit())
+				.map(|_| LiveStatement {
+					id: Uuid::new(),
+					..Default::default()
+				})
+				.collect::<Vec<_>>();
+			assert!(rpc.lives.reserve(&lives, rpc.limit(), None).is_ok());
+		}
+		// Start a live query through a query
+		let res = Rpc::query(&rpc, Strand::from("LIVE SELECT * FROM person")).await;
+		assert!(matches!(res, Err(Error::TooManyLiveQueries)));
+	}
+
+	#[tokio::test]
+	async fn query_live_rate() {
+		// Start more live queries than a scope connection can start per second
+		let sc = Rpc::new(Session::for_sc("test", "test", "user"), None);
+		let sql = "LIVE SELECT * FROM person;".repeat(MAX_SCOPE_LIVE_QUERY_RATE + 1);
+		let res = Rpc::query(&sc, Strand::from(sql.as_str())).await;
+		assert!(matches!(res, Err(Error::LiveQueryRateExceeded)));
+		assert_eq!(sc.read().await.lives.len(), 0);
+	}
+
+	#[tokio::test]
+	async fn query_live_tracked() {
+		let rpc = rpc().await;
+		// Start a live query through a query
+		let res = Rpc::query(&rpc, Strand::from("LIVE SELECT * FROM person")).await;
+		assert!(res.is_ok());
+		// Check the live query is tracked on the connection
+		let lives = rpc.read().await.lives.list();
+		assert_eq!(lives.len(), 1);
+		assert_eq!(lives[0].1.to_string(), "person");
+		assert_eq!(lives[0].2, "LIVE SELECT * FROM person");
+		// Kill the live query through the kill method
+		let res = Rpc::kill(&rpc, Value::from(lives[0].0.clone())).await.unwrap();
+		assert_eq!(
+			res.pick(&[Part::from("id")]),
+			Value::from(vec![Value::from(lives[0].0.clone())])
+		);
+		assert_eq!(rpc.read().await.lives.len(), 0);
+	}
+
+	#[tokio::test]
+	async fn query_live_denied() {
+		let rpc = rpc().await;
+		// Disable live queries on a table
+		let res = Rpc::query(&rpc, Strand::from("DEFINE TABLE denied NOLIVE")).await;
+		assert!(res.is_ok());
+		// Start a live query through a query on a scope connection
+		let sc = Rpc::new(Session::for_sc("test", "test", "user"), None);
+		let res = Rpc::query(&sc, Strand::from("LIVE SELECT * FROM denied")).await;
+		assert!(res.is_ok());
+		// Check the denied live query was counted
+		assert_eq!(sc.read().await.lives.deny(), 2);
+		assert_eq!(sc.read().await.lives.len(), 0);
+	}
+
+	#[tokio::test]
+	async fn query_kill_tracked() {
+		let rpc = rpc().await;
+		// Start a live query through the live method
+		let id = Rpc::live(&rpc, Value::from("person"), true).await.unwrap();
+		assert_eq!(rpc.read().await.lives.len(), 1);
+		// Kill a live query which was not started on this connection
+		let sql = format!("KILL {}", Uuid::new());
+		let res = Rpc::query(&rpc, Strand::from(sql.as_str())).await;
+		assert!(matches!(res, Err(Error::LiveQueryNotFound)));
+		assert_eq!(rpc.read().await.lives.len(), 1);
+		// Kill the live query through a query
+		let sql = format!("KILL {}", id);
+		let res = Rpc::query(&rpc, Strand::from(sql.as_str())).await;
+		assert!(res.is_ok());
+		assert_eq!(rpc.read().await.lives.len(), 0);
+	}
+
+	#[tokio::test]
+	async fn live_reuse_identical() {
+		let rpc = rpc().await;
+		// Start a filtered live query through a query
+		let res =
+			Rpc::query(&rpc, Strand::from("LIVE SELECT name FROM person WHERE age > 18")).await;
+		assert!(res.is_ok());
+		// Check the filtered live query is not reused for the whole table
+		let one = Rpc::live(&rpc, Value::from("person"), false).await.unwrap();
+		assert_eq!(rpc.read().await.lives.len(), 2);
+		// Check an identical live query is reused
+		let two = Rpc::live(&rpc, Value::from("person"), false).await.unwrap();
+		assert_eq!(rpc.read().await.lives.len(), 2);
+		assert_eq!(one, two);
+	}
+
+	#[tokio::test]
+	async fn kill_other_connection() {
+		let one = rpc().await;
+		let two = rpc().await;
+		// Start a live query on the first connection
+		let id = Rpc::live(&one, Value::from("person"), true).await.unwrap();
+		assert_eq!(one.read().await.lives.len(), 1);
+		// Kill the live query from the second root connection
+		let res = Rpc::kill(&two, id).await;
+		assert!(res.is_ok());
+		assert_eq!(one.read().await.lives.len(), 0);
+	}
+
+	#[tokio::test]
+	async fn query_live_shadowed() {
+		let rpc = rpc().await;
+		// Set a connection parameter with the same name
+		rpc.write().await.set(Strand::from("tb"), Value::from("person")).await.unwrap();
+		// Start a live query on a parameter defined within the query
+		let sql = "LET $tb = type::table('secret'); LIVE SELECT * FROM $tb";
+		let res = Rpc::query(&rpc, Strand::from(sql)).await;
+		assert!(res.is_ok());
+		// Check the connection parameter was not used
+		let lives = rpc.read().await.lives.list();
+		assert_eq!(lives.len(), 1);
+		assert_eq!(lives[0].1.to_string(), "$tb");
+	}
+
+	#[tokio::test]
+	async fn query_kill_shadowed() {
+		let rpc = rpc().await;
+		// Start a live query and store its id as a connection parameter
+		let id = Rpc::live(&rpc, Value::from("person"), true).await.unwrap();
+		rpc.write().await.set(Strand::from("id"), id).await.unwrap();
+		// Kill a parameter defined within the query
+		let sql = format!("LET $id = {}; KILL $id", Uuid::new());
+		let res = Rpc::query(&rpc, Strand::from(sql.as_str())).await;
+		assert!(matches!(res, Err(Error::LiveQueryNotFound)));
+		assert_eq!(rpc.read().await.lives.len(), 1);
+	}
+}
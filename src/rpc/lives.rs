@@ -0,0 +1,535 @@
+use crate::cli::CF;
+use crate::cnf::LIVE_QUERY_KILL_RETRIES;
+use crate::cnf::LIVE_QUERY_KILL_RETRY_INTERVAL;
+use crate::cnf::MAX_LIVE_QUERY_RATE;
+use crate::cnf::MAX_SCOPE_USER_LIVE_QUERIES;
+use crate::dbs::DB;
+use crate::err::Error;
+use once_cell::sync::Lazy;
+use std::collections::BTreeMap;
+use std::sync::atomic::AtomicUsize;
+use std::sync::atomic::Ordering;
+use std::sync::Arc;
+use std::sync::Mutex;
+use std::sync::RwLock;
+use std::time::Instant;
+use surrealdb::sql::statements::LiveStatement;
+use surrealdb::sql::Uuid;
+use surrealdb::sql::Value;
+use surrealdb::Auth;
+use surrealdb::Error as DbError;
+use surrealdb::Session;
+
+const LOG: &str = "surrealdb::rpc";
+
+/// Stores the live queries of every open RPC connection
+static SOCKETS: Lazy<RwLock<BTreeMap<Uuid, Arc<Socket>>>> = Lazy::new(Default::default);
+
+/// Stores how many live queries each scope user has across all RPC connections
+static USERS: Lazy<Mutex<BTreeMap<String, usize>>> = Lazy::new(Default::default);
+
+/// A live query which is active on an RPC connection
+struct Live {
+	stm: LiveStatement,
+	user: Option<String>,
+}
+
+/// A live query which is being started on an RPC connection
+struct Pending {
+	stm: LiveStatement,
+	user: Option<String>,
+	cancelled: bool,
+}
+
+/// The live queries of an RPC connection
+#[derive(Default)]
+struct State {
+	lives: BTreeMap<Uuid, Live>,
+	pending: BTreeMap<Uuid, Pending>,
+	closed: bool,
+}
+
+/// The namespace, database, and authentication level of the live queries of an RPC connection
+#[derive(Clone, Default)]
+struct Labels {
+	ns: String,
+	db: String,
+	au: &'static str,
+}
+
+/// The live query tokens of an RPC connection
+struct Bucket {
+	tokens: f64,
+	refill: Instant,
+}
+
+impl Default for Bucket {
+	fn default() -> Self {
+		Bucket {
+			tokens: MAX_LIVE_QUERY_RATE as f64,
+			refill: Instant::now(),
+		}
+	}
+}
+
+/// Stores the live queries of an RPC connection, so that they
+/// can be changed without holding the lock on the connection
+#[derive(Default)]
+pub struct Socket {
+	id: Uuid,
+	count: AtomicUsize,
+	denied: AtomicUsize,
+	bucket: Mutex<Bucket>,
+	labels: Mutex<Labels>,
+	state: Mutex<State>,
+}
+
+impl Socket {
+	/// Creates the live queries store for a new RPC connection
+	pub fn new() -> Arc<Socket> {
+		let socket = Arc::new(Socket {
+			id: Uuid::new(),
+			..Default::default()
+		});
+		SOCKETS.write().unwrap().insert(socket.id.clone(), socket.clone());
+		socket
+	}
+
+	/// Takes live query tokens, refilling them at the specified rate per second
+	pub fn throttle(&self, count: usize, rate: f64) -> bool {
+		let mut bucket = self.bucket.lock().unwrap();
+		// Refill the tokens since the last check
+		let now = Instant::now();
+		let secs = now.duration_since(bucket.refill).as_secs_f64();
+		bucket.tokens = (bucket.tokens + secs * rate).min(rate);
+		bucket.refill = now;
+		// Check if there are enough tokens available
+		if bucket.tokens < count as f64 {
+			return false;
+		}
+		// Take the tokens
+		bucket.tokens -= count as f64;
+		true
+	}
+
+	/// Counts a denied live query, returning the number of denied live queries
+	pub fn deny(&self) -> usize {
+		self.denied.fetch_add(1, Ordering::Relaxed) + 1
+	}
+
+	/// Counts the live queries which are active on this connection
+	pub fn len(&self) -> usize {
+		self.state.lock().unwrap().lives.len()
+	}
+
+	/// Checks if a live query is active on this connection
+	pub fn contains(&self, id: &Uuid) -> bool {
+		self.state.lock().unwrap().lives.contains_key(id)
+	}
+
+	/// Finds an active live query which is identical to the specified live query
+	pub fn find(&self, stm: &LiveStatement) -> Option<Uuid> {
+		let state = self.state.lock().unwrap();
+		state
+			.lives
+			.iter()
+			.find(|(_, v)| {
+				v.stm.expr == stm.expr
+					&& v.stm.what == stm.what
+					&& v.stm.cond == stm.cond
+					&& v.stm.fetch == stm.fetch
+			})
+			.map(|(id, _)| id.clone())
+	}
+
+	/// Lists the live queries which are active on this connection, with the statement which started them
+	pub fn list(&self) -> Vec<(Uuid, Value, String)> {
+		let state = self.state.lock().unwrap();
+		state
+			.lives
+			.iter()
+			.map(|(id, v)| (id.clone(), v.stm.what.clone(), v.stm.to_string()))
+			.collect()
+	}
+
+	/// Reserves slots for live queries which are being started, within the
+	/// live query limit of this connection and of the scope user
+	pub fn reserve(
+		&self,
+		lives: &[LiveStatement],
+		limit: usize,
+		user: Option<String>,
+	) -> Result<(), Error> {
+		let mut state = self.state.lock().unwrap();
+		// Check the live query limit
+		if state.lives.len() + state.pending.len() + lives.len() > limit {
+			return Err(Error::TooManyLiveQueries);
+		}
+		// Check the live query limit for this scope user
+		if let Some(user) = &user {
+			if !reserve(user, lives.len()) {
+				return Err(Error::TooManyScopeLiveQueries);
+			}
+		}
+		// Reserve a slot for each live query
+		for stm in lives {
+			let pending = Pending {
+				stm: stm.clone(),
+				user: user.clone(),
+				cancelled: false,
+			};
+			state.pending.insert(stm.id.clone(), pending);
+		}
+		Ok(())
+	}
+
+	/// Stores a live query which has been started, unless it was cancelled meanwhile
+	pub fn start(&self, id: &Uuid, session: &Session) -> bool {
+		let mut state = self.state.lock().unwrap();
+		match state.pending.remove(id) {
+			Some(v) if !v.cancelled && !state.closed => {
+				// Label the live queries for the summary
+				*self.labels.lock().unwrap() = Labels {
+					ns: session.ns.clone().unwrap_or_default(),
+					db: session.db.clone().unwrap_or_default(),
+					au: kind(&session.au),
+				};
+				self.count.fetch_add(1, Ordering::Relaxed);
+				let live = Live {
+					stm: v.stm,
+					user: v.user,
+				};
+				state.lives.insert(id.clone(), live);
+				true
+			}
+			Some(v) => {
+				release(v.user.as_deref());
+				false
+			}
+			None => false,
+		}
+	}
+
+	/// Releases the slot of a live query which could not be started
+	pub fn abort(&self, id: &Uuid) {
+		if let Some(v) = self.state.lock().unwrap().pending.remove(id) {
+			release(v.user.as_deref());
+		}
+	}
+
+	/// Removes the matching live queries, and cancels the matching live queries being started
+	pub fn take(&self, check: impl Fn(&Uuid, &Value) -> bool) -> Vec<(Uuid, Value)> {
+		let mut state = self.state.lock().unwrap();
+		// Cancel the live queries which are being started
+		for (id, v) in state.pending.iter_mut() {
+			if check(id, &v.stm.what) {
+				v.cancelled = true;
+			}
+		}
+		// Remove the live queries which are active
+		let ids =
+			state.lives.iter().filter(|(id, v)| check(id, &v.stm.what)).map(|(id, _)| id.clone());
+		let ids = ids.collect::<Vec<Uuid>>();
+		ids.into_iter()
+			.filter_map(|id| {
+				let v = state.lives.remove(&id)?;
+				self.count.fetch_sub(1, Ordering::Relaxed);
+				release(v.user.as_deref());
+				Some((id, v.stm.what))
+			})
+			.collect()
+	}
+
+	/// Removes all of the live queries as the connection is closing
+	pub fn close(&self) -> Vec<(Uuid, Value)> {
+		SOCKETS.write().unwrap().remove(&self.id);
+		self.state.lock().unwrap().closed = true;
+		self.take(|_, _| true)
+	}
+}
+
+/// Finds the RPC connection on which a live query was started
+pub fn owner(id: &Uuid) -> Option<Arc<Socket>> {
+	let sockets = SOCKETS.read().unwrap().values().cloned().collect::<Vec<Arc<Socket>>>();
+	sockets.into_iter().find(|v| v.contains(id))
+}
+
+/// Checks if a live query target is on the specified table
+pub fn on(what: &Value, tb: &str) -> bool {
+	match what {
+		Value::Table(v) => v.0 == tb,
+		Value::Thing(v) => v.tb == tb,
+		_ => false,
+	}
+}
+
+/// Returns the name of the authentication level
+fn kind(au: &Auth) -> &'static str {
+	match au {
+		Auth::No => "no",
+		Auth::Kv => "kv",
+		Auth::Ns(_) => "ns",
+		Auth::Db(_, _) => "db",
+		Auth::Sc(_, _, _) => "sc",
+	}
+}
+
+/// Returns the identity of a scope authenticated session
+pub fn identity(session: &Session) -> Option<String> {
+	match (session.au.as_ref(), &session.sd) {
+		(Auth::Sc(ns, db, sc), Some(sd)) => Some(format!("{}/{}/{}/{}", ns, db, sc, sd)),
+		_ => None,
+	}
+}
+
+/// Reserves live query slots for a scope user, if they fit within the limit
+fn reserve(user: &str, count: usize) -> bool {
+	let mut users = USERS.lock().unwrap();
+	// Check the live query limit for this scope user
+	let total = users.get(user).copied().unwrap_or_default();
+	if total + count > MAX_SCOPE_USER_LIVE_QUERIES {
+		return false;
+	}
+	// Reserve the live query slots
+	users.insert(user.to_owned(), total + count);
+	true
+}
+
+/// Releases a live query slot for a scope user
+fn release(user: Option<&str>) {
+	if let Some(user) = user {
+		let mut users = USERS.lock().unwrap();
+		let found = match users.get_mut(user) {
+			Some(total) => {
+				*total = total.saturating_sub(1);
+				if *total == 0 {
+					users.remove(user);
+				}
+				true
+			}
+			None => false,
+		};
+		// Report a slot which is released more than once, without poisoning the lock
+		drop(users);
+		if !found {
+			error!(target: LOG, "Released a live query slot for {} which was not reserved", user);
+			debug_assert!(found, "released a live query slot which was not reserved");
+		}
+	}
+}
+
+/// Retries killing live queries which could not be killed on an RPC connection
+pub fn reap(session: Session, mut ids: Vec<Uuid>) {
+	tokio::spawn(async move {
+		for _ in 0..LIVE_QUERY_KILL_RETRIES {
+			// Wait before retrying the live queries
+			tokio::time::sleep(LIVE_QUERY_KILL_RETRY_INTERVAL).await;
+			// Kill the live queries which are still active
+			ids = match kill(&session, &ids).await {
+				Ok(res) => {
+					ids.into_iter().zip(res).filter(|(_, v)| v.is_err()).map(|(id, _)| id).collect()
+				}
+				Err(e) => {
+					warn!(target: LOG, "Unable to kill {} live queries: {}", ids.len(), e);
+					ids
+				}
+			};
+			if ids.is_empty() {
+				return;
+			}
+		}
+		// Report the live queries which could not be killed
+		for id in ids {
+			error!(target: LOG, "Gave up killing live query {}", id.to_raw());
+		}
+	});
+}
+
+/// Kills live queries, returning the result for each live query, where
+/// live queries which were already removed from the datastore are killed
+pub async fn kill(session: &Session, ids: &[Uuid]) -> Result<Vec<Result<(), DbError>>, DbError> {
+	// Get a database reference
+	let kvs = DB.get().unwrap();
+	// Get local copy of options
+	let opt = CF.get().unwrap();
+	// Specify the SQL query string
+	let sql = ids.iter().map(|id| format!("KILL {};", id)).collect::<String>();
+	// Execute the query on the database
+	let res = kvs.execute(&sql, session, None, opt.strict).await?;
+	// Check each of the query results
+	let res = res
+		.into_iter()
+		.map(|v| match v.result {
+			Ok(_)
+			| Err(DbError::KillStatement {
+				..
+			}) => Ok(()),
+			Err(e) => Err(e),
+		})
+		.collect();
+	Ok(res)
+}
+
+/// Summarises the active live queries by namespace, database, and authentication level
+pub fn summary() -> Value {
+	// Fetch the open connections without holding the lock
+	let sockets = SOCKETS.read().unwrap().values().cloned().collect::<Vec<Arc<Socket>>>();
+	// Count the live queries on each connection
+	let mut all = 0;
+	let mut out: BTreeMap<String, BTreeMap<String, BTreeMap<&str, usize>>> = BTreeMap::new();
+	for socket in sockets {
+		let count = socket.count.load(Ordering::Relaxed);
+		if count > 0 {
+			let labels = socket.labels.lock().unwrap().clone();
+			let aus = out.entry(labels.ns).or_default().entry(labels.db).or_default();
+			*aus.entry(labels.au).or_default() += count;
+			all += count;
+		}
+	}
+	// Convert the tallies into a response
+	let namespaces = out
+		.into_iter()
+		.map(|(ns, dbs)| {
+			let mut total = 0;
+			let databases = dbs
+				.into_iter()
+				.map(|(db, aus)| {
+					let count: usize = aus.values().sum();
+					total += count;
+					let auth: BTreeMap<String, Value> =
+						aus.into_iter().map(|(k, v)| (k.to_owned(), Value::from(v))).collect();
+					let value = Value::from(map! {
+						String::from("total") => Value::from(count),
+						String::from("auth") => Value::from(auth),
+					});
+					(db, value)
+				})
+				.collect::<BTreeMap<String, Value>>();
+			let value = Value::from(map! {
+				String::from("total") => Value::from(total),
+				String::from("databases") => Value::from(databases),
+			});
+			(ns, value)
+		})
+		.collect::<BTreeMap<String, Value>>();
+	Value::from(map! {
+		String::from("total") => Value::from(all),
+		String::from("namespaces") => Value::from(namespaces),
+	})
+}
+
+#[cfg(test)]
+mod tests {
+
+	use super::*;
+
+	// Create a live query on a new target
+	fn stm() -> LiveStatement {
+		LiveStatement {
+			id: Uuid::new(),
+			..Default::default()
+		}
+	}
+
+	// Count the live query slots which are reserved for a scope user
+	fn slots(user: &str) -> usize {
+		USERS.lock().unwrap().get(user).copied().unwrap_or_default()
+	}
+
+	#[test]
+	fn reserve_abort() {
+		let socket = Socket::new();
+		let user = Uuid::new().to_raw();
+		let live = stm();
+		let id = live.id.clone();
+		assert!(socket.reserve(&[live], 10, Some(user.clone())).is_ok());
+		assert_eq!(slots(&user), 1);
+		// Abort the live query twice
+		socket.abort(&id);
+		socket.abort(&id);
+		assert_eq!(slots(&user), 0);
+		assert!(!socket.start(&id, &Session::for_kv()));
+		assert_eq!(socket.len(), 0);
+		socket.close();
+	}
+
+	#[test]
+	fn reserve_start_take() {
+		let socket = Socket::new();
+		let user = Uuid::new().to_raw();
+		let live = stm();
+		let id = live.id.clone();
+		assert!(socket.reserve(&[live], 10, Some(user.clone())).is_ok());
+		assert!(socket.start(&id, &Session::for_kv()));
+		assert_eq!(socket.len(), 1);
+		assert_eq!(slots(&user), 1);
+		// Take the live query twice
+		assert_eq!(socket.take(|v, _| *v == id).len(), 1);
+		assert_eq!(socket.take(|v, _| *v == id).len(), 0);
+		assert_eq!(socket.len(), 0);
+		assert_eq!(slots(&user), 0);
+		socket.close();
+	}
+
+	#[test]
+	fn reserve_take_start() {
+		let socket = Socket::new();
+		let user = Uuid::new().to_raw();
+		let live = stm();
+		let id = live.id.clone();
+		assert!(socket.reserve(&[live], 10, Some(user.clone())).is_ok());
+		// Cancel the live query while it is being started
+		assert_eq!(socket.take(|v, _| *v == id).len(), 0);
+		assert_eq!(slots(&user), 1);
+		assert!(!socket.start(&id, &Session::for_kv()));
+		assert_eq!(slots(&user), 0);
+		// Abort the live query after it was discarded
+		socket.abort(&id);
+		assert_eq!(slots(&user), 0);
+		assert_eq!(socket.len(), 0);
+		socket.close();
+	}
+
+	#[test]
+	fn reserve_close_start() {
+		let socket = Socket::new();
+		let user = Uuid::new().to_raw();
+		let one = stm();
+		let two = stm();
+		let (a, b) = (one.id.clone(), two.id.clone());
+		assert!(socket.reserve(&[one, two], 10, Some(user.clone())).is_ok());
+		assert!(socket.start(&a, &Session::for_kv()));
+		// Close the connection while a live query is being started
+		assert_eq!(socket.close().len(), 1);
+		assert_eq!(slots(&user), 1);
+		assert!(!socket.start(&b, &Session::for_kv()));
+		assert_eq!(slots(&user), 0);
+		assert_eq!(socket.len(), 0);
+		assert!(owner(&a).is_none());
+	}
+
+	#[test]
+	fn reserve_limits() {
+		let socket = Socket::new();
+		let user = Uuid::new().to_raw();
+		// Exceed the live query limit of the connection
+		let lives = vec![stm(), stm()];
+		assert!(matches!(
+			socket.reserve(&lives, 1, Some(user.clone())),
+			Err(Error::TooManyLiveQueries)
+		));
+		assert_eq!(slots(&user), 0);
+		// Exceed the live query limit of the scope user
+		let lives = (0..=MAX_SCOPE_USER_LIVE_QUERIES).map(|_| stm()).collect::<Vec<_>>();
+		assert!(matches!(
+			socket.reserve(&lives, usize::MAX, Some(user.clone())),
+			Err(Error::TooManyScopeLiveQueries)
+		));
+		assert_eq!(slots(&user), 0);
+		// Check that no slots were reserved on the connection
+		let lives = vec![stm()];
+		assert!(socket.reserve(&lives, 1, None).is_ok());
+		socket.abort(&lives[0].id);
+		socket.close();
+	}
+}
@@ -1,3 +1,4 @@
 pub mod args;
+pub mod lives;
 pub mod paths;
 pub mod res;
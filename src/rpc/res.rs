@@ -1,7 +1,9 @@
+use crate::err::Error;
 use serde::Serialize;
 use std::borrow::Cow;
 use surrealdb::channel::Sender;
 use surrealdb::sql::Value;
+use surrealdb::Error as DbError;
 use warp::ws::Message;
 
 #[derive(Serialize)]
@@ -74,6 +76,23 @@ impl Failure {
 		message: Cow::Borrowed("Internal error"),
 	};
 
+	pub const BUSY: Failure = Failure {
+		code: -32004,
+		message: Cow::Borrowed("Too many concurrent requests"),
+	};
+
+	pub const LIVE_LIMIT_CODE: i64 = -32001;
+
+	pub const LIVE_TARGET_CODE: i64 = -32002;
+
+	pub const KILL_TARGET_CODE: i64 = -32003;
+
+	pub const LIVE_RATE_CODE: i64 = -32005;
+
+	pub const KILL_OWNER_CODE: i64 = -32006;
+
+	pub const LIVE_PERMISSION_CODE: i64 = -32007;
+
 	pub fn custom<S>(message: S) -> Failure
 	where
 		Cow<'static, str>: From<S>,
@@ -84,3 +103,36 @@ impl Failure {
 		}
 	}
 }
+
+impl From<Error> for Failure {
+	fn from(err: Error) -> Self {
+		let code = match err {
+			Error::TooManyLiveQueries => Failure::LIVE_LIMIT_CODE,
+			Error::TooManyScopeLiveQueries => Failure::LIVE_LIMIT_CODE,
+			Error::LiveQueryRateLimit => Failure::LIVE_RATE_CODE,
+			Error::LiveQueryRateExceeded => Failure::LIVE_LIMIT_CODE,
+			Error::LiveQueryNotFound => Failure::KILL_OWNER_CODE,
+			Error::LiveQueryDatabase => Failure::LIVE_TARGET_CODE,
+			Error::Db(DbError::LiveStatement {
+				..
+			}) => Failure::LIVE_TARGET_CODE,
+			Error::Db(DbError::TableViewLiveQueries {
+				..
+			}) => Failure::LIVE_TARGET_CODE,
+			Error::Db(DbError::TableLiveQueries {
+				..
+			}) => Failure::LIVE_PERMISSION_CODE,
+			Error::Db(DbError::KillStatement {
+				..
+			}) => Failure::KILL_TARGET_CODE,
+			Error::Db(DbError::KillStatementParam {
+				..
+			}) => Failure::KILL_TARGET_CODE,
+			_ => return Failure::custom(err.to_string()),
+		};
+		Failure {
+			code,
+			message: Cow::Owned(err.to_string()),
+		}
+	}
+}